@@ -25,27 +25,63 @@ import (
 type Conn interface {
 	net.Conn
 	ByteTracker
+
+	// Subscribe registers fn to be called asynchronously with the
+	// read/written byte deltas of every Read/Write. It returns a func that
+	// unsubscribes fn. Slow observers have their oldest unconsumed delta
+	// dropped rather than blocking the data path.
+	Subscribe(fn func(readDelta, writtenDelta uint64)) (unsubscribe func())
+
+	// Totals returns the running read/written byte counts with a single
+	// atomic load each, unlike BytesReadWritten it never waits for
+	// in-flight Read/Write calls to finish.
+	Totals() (read, written uint64)
+
+	// ReadLatency returns a Histogram of wall-clock durations, in
+	// nanoseconds, of calls to Read.
+	ReadLatency() Histogram
+	// WriteLatency returns a Histogram of wall-clock durations, in
+	// nanoseconds, of calls to Write.
+	WriteLatency() Histogram
+	// IOSizes returns Histograms, in bytes, of the sizes of successful
+	// Reads and Writes.
+	IOSizes() (read, written Histogram)
 }
 
-// newConn returns a new Conn based off of a net.Conn
-func newConn(conn net.Conn) *basicConn {
-	// Must set a deadline otherwise we risk
-	// waiting forever on observation
-	conn.SetReadDeadline(time.Now().Add(time.Second * 60))
-	conn.SetWriteDeadline(time.Now().Add(time.Second * 60))
-	return &basicConn{Conn: conn}
+// newConn returns a new Conn based off of a net.Conn, applying opts on top
+// of the default deadline policy.
+func newConn(conn net.Conn, opts ...Option) *basicConn {
+	cfg := newConnConfig(opts...)
+	c := &basicConn{
+		Conn:         conn,
+		cfg:          cfg,
+		readLatency:  newShardedHistogram(),
+		writeLatency: newShardedHistogram(),
+		readSizes:    newShardedHistogram(),
+		writeSizes:   newShardedHistogram(),
+	}
+	if !cfg.noDeadline {
+		// Must set a deadline otherwise we risk
+		// waiting forever on observation
+		conn.SetReadDeadline(cfg.deadlineFunc())
+		conn.SetWriteDeadline(cfg.deadlineFunc())
+	}
+	return c
 }
 
-// NewConn returns a new Conn based off of a net.Conn
-func NewConn(conn net.Conn) Conn {
-	return newConn(conn)
+// NewConn returns a new Conn based off of a net.Conn. By default it pushes a
+// 60 second read/write deadline forward after every Read/Write; use
+// WithIdleTimeout, WithNoDeadline, or WithDeadlineFunc to change that policy,
+// and WithOnClose to register a close hook.
+func NewConn(conn net.Conn, opts ...Option) Conn {
+	return newConn(conn, opts...)
 }
 
 type basicConn struct {
 	bytesRead    uint64
 	bytesWritten uint64
 	net.Conn
-	OnClose func()
+	cfg *connConfig
 
 	activeOps sync.WaitGroup
 
@@ -56,6 +92,14 @@ type basicConn struct {
 	// of WaitGroup and must be synchronized every time
 	// activeOps' state counter goes back to 0.
 	activeOpsMu sync.RWMutex
+
+	observersMu sync.RWMutex
+	observers   []*observer
+
+	readLatency  *shardedHistogram
+	writeLatency *shardedHistogram
+	readSizes    *shardedHistogram
+	writeSizes   *shardedHistogram
 }
 
 func (conn *basicConn) Read(b []byte) (n int, err error) {
@@ -63,12 +107,18 @@ func (conn *basicConn) Read(b []byte) (n int, err error) {
 	conn.activeOps.Add(1)
 	conn.activeOpsMu.RUnlock()
 
+	start := time.Now()
 	n, err = conn.Conn.Read(b)
+	conn.readLatency.record(int64(time.Since(start)))
 	if n > 0 {
 		atomic.AddUint64(&conn.bytesRead, uint64(n))
+		conn.readSizes.record(int64(n))
+		conn.notify(uint64(n), 0)
 	}
 	conn.activeOps.Done()
-	conn.SetReadDeadline(time.Now().Add(time.Second * 60))
+	if !conn.cfg.noDeadline {
+		conn.SetReadDeadline(conn.cfg.deadlineFunc())
+	}
 	return n, err
 }
 
@@ -77,20 +127,27 @@ func (conn *basicConn) Write(b []byte) (n int, err error) {
 	conn.activeOps.Add(1)
 	conn.activeOpsMu.RUnlock()
 
+	start := time.Now()
 	n, err = conn.Conn.Write(b)
+	conn.writeLatency.record(int64(time.Since(start)))
 	if n > 0 {
 		atomic.AddUint64(&conn.bytesWritten, uint64(n))
+		conn.writeSizes.record(int64(n))
+		conn.notify(0, uint64(n))
 	}
 	conn.activeOps.Done()
-	conn.SetWriteDeadline(time.Now().Add(time.Second * 60))
+	if !conn.cfg.noDeadline {
+		conn.SetWriteDeadline(conn.cfg.deadlineFunc())
+	}
 	return n, err
 }
 
 func (conn *basicConn) Close() error {
 	err := conn.Conn.Close()
-	if conn.OnClose != nil {
-		conn.OnClose()
+	if conn.cfg.onClose != nil {
+		conn.cfg.onClose()
 	}
+	conn.closeObservers()
 	return err
 }
 
@@ -106,3 +163,19 @@ func (conn *basicConn) ResetBytes() {
 	atomic.StoreUint64(&conn.bytesRead, 0)
 	atomic.StoreUint64(&conn.bytesWritten, 0)
 }
+
+func (conn *basicConn) Totals() (read, written uint64) {
+	return atomic.LoadUint64(&conn.bytesRead), atomic.LoadUint64(&conn.bytesWritten)
+}
+
+func (conn *basicConn) ReadLatency() Histogram {
+	return conn.readLatency
+}
+
+func (conn *basicConn) WriteLatency() Histogram {
+	return conn.writeLatency
+}
+
+func (conn *basicConn) IOSizes() (read, written Histogram) {
+	return conn.readSizes, conn.writeSizes
+}
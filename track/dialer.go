@@ -0,0 +1,94 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"context"
+	"net"
+)
+
+// Dialer wraps every net.Conn it dials in a Conn and registers it in a
+// Registry.
+type Dialer interface {
+	// Dial connects to address on the named network, see net.Dialer.Dial.
+	Dial(network, address string) (net.Conn, error)
+
+	// Registry returns the Registry tracking connections dialed by this
+	// Dialer.
+	Registry() *Registry
+}
+
+// ContextDialer is a Dialer that also supports dialing with a context, see
+// net.Dialer.DialContext.
+type ContextDialer interface {
+	Dialer
+
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+type trackingDialer struct {
+	dialer   *net.Dialer
+	registry *Registry
+
+	rateLimited              bool
+	readBps, writeBps, burst int64
+}
+
+// NewDialer returns a ContextDialer that wraps every net.Conn dialed with d
+// in a Conn and registers it in its Registry. If d is nil, a zero-value
+// net.Dialer is used.
+func NewDialer(d *net.Dialer) ContextDialer {
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	return &trackingDialer{dialer: d, registry: NewRegistry()}
+}
+
+// NewRateLimitedDialer is like NewDialer, but additionally shapes every
+// dialed Conn with a token bucket (see NewRateLimitedConn) and enrolls it in
+// the Registry's global limit, so Registry.SetGlobalLimit divides bandwidth
+// across every connection this Dialer has dialed.
+func NewRateLimitedDialer(d *net.Dialer, readBps, writeBps, burst int64) ContextDialer {
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	return &trackingDialer{
+		dialer:      d,
+		registry:    NewRegistry(),
+		rateLimited: true,
+		readBps:     readBps,
+		writeBps:    writeBps,
+		burst:       burst,
+	}
+}
+
+func (d *trackingDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *trackingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if d.rateLimited {
+		return d.registry.wrapRateLimited(conn, d.readBps, d.writeBps, d.burst), nil
+	}
+	return d.registry.wrap(conn), nil
+}
+
+func (d *trackingDialer) Registry() *Registry {
+	return d.registry
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialerRegistersDialedConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	d := NewDialer(nil)
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(Conn); !ok {
+		t.Fatalf("Dial returned %T, want a Conn", conn)
+	}
+	if _, _, active := d.Registry().Aggregate(); active != 1 {
+		t.Fatalf("active = %d, want 1", active)
+	}
+
+	conn.Close()
+	deadline := time.After(2 * time.Second)
+	for {
+		if _, _, active := d.Registry().Aggregate(); active == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("dialed conn was never deregistered after Close")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDialerDialContextHonorsCancellation(t *testing.T) {
+	d := NewDialer(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.DialContext(ctx, "tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("DialContext with an already-cancelled context returned no error")
+	}
+}
+
+func TestRateLimitedDialerSharesGlobalLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	d := NewRateLimitedDialer(nil, 1000, NoLimit, 100)
+	d.Registry().SetGlobalLimit(1000, NoLimit)
+
+	conn1, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial 1: %v", err)
+	}
+	defer conn1.Close()
+	rl1, ok := conn1.(RateLimiter)
+	if !ok {
+		t.Fatalf("Dial with NewRateLimitedDialer returned %T, want a RateLimiter", conn1)
+	}
+	if got := rl1.(*rateLimitedConn).readLimiter; got.rate != 1000 {
+		t.Fatalf("solo conn share = %v, want 1000", got.rate)
+	}
+
+	conn2, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial 2: %v", err)
+	}
+	defer conn2.Close()
+
+	if got := rl1.(*rateLimitedConn).readLimiter; got.rate != 500 {
+		t.Fatalf("rebalanced share = %v, want 500 with two members", got.rate)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"math"
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// histSubBuckets is the number of linear sub-buckets per power-of-two
+// octave, giving roughly 2 significant figures of resolution, HDR-histogram
+// style.
+const histSubBuckets = 32
+
+// histMinExp/histMaxExp bound the octaves a histogram tracks. A value of 1
+// in the histogram's native unit falls in octave 0; octave 36 covers values
+// from 2^36 up to 2^37 (larger values are counted in its top sub-bucket),
+// comfortably spanning both 1ns-60s (about 2^36 ns) and 1B-1GiB (2^30 bytes).
+const (
+	histMinExp    = 0
+	histMaxExp    = 36
+	histNumBucket = (histMaxExp - histMinExp + 1) * histSubBuckets
+)
+
+// maxHistShards caps the number of shards a shardedHistogram allocates,
+// independent of runtime.GOMAXPROCS(0). Each shard's bucket array is
+// histNumBucket*8 bytes, so letting the shard count track GOMAXPROCS
+// verbatim would make every tracked Conn's four histograms (read/write
+// latency, read/write size) scale linearly with core count - multiple
+// megabytes per Conn on a large machine, paid whether or not anyone ever
+// reads the histogram back.
+const maxHistShards = 4
+
+// Histogram is a read-only view over recorded latency or size samples.
+type Histogram interface {
+	// Percentile returns an approximation of the value at percentile p
+	// (0 <= p <= 1).
+	Percentile(p float64) int64
+	// Count returns the number of samples recorded.
+	Count() uint64
+	// Sum returns the sum of all recorded sample values.
+	Sum() uint64
+	// Merge folds other's samples into this Histogram.
+	Merge(other Histogram)
+}
+
+// histShard holds one shard's worth of bucket counters. Every field is only
+// ever touched with atomic operations so shards need no lock.
+type histShard struct {
+	buckets [histNumBucket]uint64
+	count   uint64
+	sum     uint64
+}
+
+// shardedHistogram is a lock-free histogram: recordings are spread round-
+// robin across a fixed set of shards to avoid cache-line contention on the
+// hot path, and reads merge all shards together. Shards hold their bucket
+// array by pointer and are allocated lazily, on first record() into them,
+// so a shardedHistogram that sees little or no traffic costs little more
+// than its shard-pointer slice.
+type shardedHistogram struct {
+	shards    []unsafe.Pointer // each *histShard, lazily allocated
+	numShards int
+	next      uint64
+}
+
+// NewHistogram returns an empty Histogram, suitable as a merge target when
+// aggregating several Conns' histograms together.
+func NewHistogram() Histogram {
+	return newShardedHistogram()
+}
+
+func newShardedHistogram() *shardedHistogram {
+	n := runtime.GOMAXPROCS(0)
+	if n < 1 {
+		n = 1
+	}
+	if n > maxHistShards {
+		n = maxHistShards
+	}
+	return &shardedHistogram{shards: make([]unsafe.Pointer, n), numShards: n}
+}
+
+// shardAt returns the shard at idx, allocating it on first use. Concurrent
+// callers racing to create the same shard converge on a single winner via
+// CompareAndSwapPointer.
+func (h *shardedHistogram) shardAt(idx int) *histShard {
+	if p := atomic.LoadPointer(&h.shards[idx]); p != nil {
+		return (*histShard)(p)
+	}
+	created := unsafe.Pointer(&histShard{})
+	if atomic.CompareAndSwapPointer(&h.shards[idx], nil, created) {
+		return (*histShard)(created)
+	}
+	return (*histShard)(atomic.LoadPointer(&h.shards[idx]))
+}
+
+func (h *shardedHistogram) record(v int64) {
+	idx := bucketIndex(v)
+	i := int(atomic.AddUint64(&h.next, 1) % uint64(h.numShards))
+	shard := h.shardAt(i)
+	atomic.AddUint64(&shard.buckets[idx], 1)
+	atomic.AddUint64(&shard.count, 1)
+	atomic.AddUint64(&shard.sum, uint64(v))
+}
+
+// merged returns the bucket counts summed across every allocated shard.
+func (h *shardedHistogram) merged() [histNumBucket]uint64 {
+	var out [histNumBucket]uint64
+	for i := 0; i < h.numShards; i++ {
+		p := atomic.LoadPointer(&h.shards[i])
+		if p == nil {
+			continue
+		}
+		shard := (*histShard)(p)
+		for i := range shard.buckets {
+			if c := atomic.LoadUint64(&shard.buckets[i]); c > 0 {
+				out[i] += c
+			}
+		}
+	}
+	return out
+}
+
+func (h *shardedHistogram) Count() uint64 {
+	var total uint64
+	for i := 0; i < h.numShards; i++ {
+		p := atomic.LoadPointer(&h.shards[i])
+		if p == nil {
+			continue
+		}
+		total += atomic.LoadUint64(&(*histShard)(p).count)
+	}
+	return total
+}
+
+func (h *shardedHistogram) Sum() uint64 {
+	var total uint64
+	for i := 0; i < h.numShards; i++ {
+		p := atomic.LoadPointer(&h.shards[i])
+		if p == nil {
+			continue
+		}
+		total += atomic.LoadUint64(&(*histShard)(p).sum)
+	}
+	return total
+}
+
+func (h *shardedHistogram) Percentile(p float64) int64 {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(p * float64(total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var cumulative uint64
+	buckets := h.merged()
+	for i, c := range buckets {
+		cumulative += c
+		if cumulative >= target {
+			return bucketValue(i)
+		}
+	}
+	return bucketValue(histNumBucket - 1)
+}
+
+// Merge folds other's recorded samples into h. other must also be a
+// *shardedHistogram, as returned by NewHistogram or a Conn's latency/size
+// accessors; histograms from other implementations are ignored.
+func (h *shardedHistogram) Merge(other Histogram) {
+	o, ok := other.(*shardedHistogram)
+	if !ok {
+		return
+	}
+	dst := h.shardAt(0)
+	for i := 0; i < o.numShards; i++ {
+		p := atomic.LoadPointer(&o.shards[i])
+		if p == nil {
+			continue
+		}
+		src := (*histShard)(p)
+		for i := range src.buckets {
+			if c := atomic.LoadUint64(&src.buckets[i]); c > 0 {
+				atomic.AddUint64(&dst.buckets[i], c)
+			}
+		}
+		atomic.AddUint64(&dst.count, atomic.LoadUint64(&src.count))
+		atomic.AddUint64(&dst.sum, atomic.LoadUint64(&src.sum))
+	}
+}
+
+// bucketIndex maps v into the octave/sub-bucket scheme described by
+// histSubBuckets/histMinExp/histMaxExp.
+func bucketIndex(v int64) int {
+	if v < 1 {
+		v = 1
+	}
+	exp := bits.Len64(uint64(v)) - 1
+	if exp < histMinExp {
+		exp = histMinExp
+	}
+	if exp > histMaxExp {
+		exp = histMaxExp
+	}
+
+	base := int64(1) << uint(exp)
+	sub := int(((v - base) * histSubBuckets) / base)
+	if sub >= histSubBuckets {
+		sub = histSubBuckets - 1
+	}
+	return (exp-histMinExp)*histSubBuckets + sub
+}
+
+// bucketValue returns the representative value (the sub-bucket midpoint)
+// for bucket index idx.
+func bucketValue(idx int) int64 {
+	exp := idx/histSubBuckets + histMinExp
+	sub := idx % histSubBuckets
+
+	base := int64(1) << uint(exp)
+	width := base / histSubBuckets
+	if width < 1 {
+		width = 1
+	}
+	return base + int64(sub)*base/histSubBuckets + width/2
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import "net"
+
+// Listener wraps a net.Listener so that every accepted connection is
+// returned as a Conn and registered in a Registry.
+type Listener interface {
+	net.Listener
+
+	// Registry returns the Registry tracking connections accepted by this
+	// Listener.
+	Registry() *Registry
+}
+
+type trackingListener struct {
+	net.Listener
+	registry *Registry
+
+	rateLimited              bool
+	readBps, writeBps, burst int64
+}
+
+// NewListener returns a Listener that wraps every net.Conn accepted from l
+// in a Conn and registers it in its Registry.
+func NewListener(l net.Listener) Listener {
+	return &trackingListener{Listener: l, registry: NewRegistry()}
+}
+
+// NewRateLimitedListener is like NewListener, but additionally shapes every
+// accepted Conn with a token bucket (see NewRateLimitedConn) and enrolls it
+// in the Registry's global limit, so Registry.SetGlobalLimit divides
+// bandwidth across every connection this Listener has accepted.
+func NewRateLimitedListener(l net.Listener, readBps, writeBps, burst int64) Listener {
+	return &trackingListener{
+		Listener:    l,
+		registry:    NewRegistry(),
+		rateLimited: true,
+		readBps:     readBps,
+		writeBps:    writeBps,
+		burst:       burst,
+	}
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.rateLimited {
+		return l.registry.wrapRateLimited(conn, l.readBps, l.writeBps, l.burst), nil
+	}
+	return l.registry.wrap(conn), nil
+}
+
+func (l *trackingListener) Registry() *Registry {
+	return l.registry
+}
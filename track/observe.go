@@ -0,0 +1,132 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import "sync"
+
+// observerBuffer is the number of undelivered deltas an observer may
+// accumulate before the oldest one is dropped to make room for the newest.
+const observerBuffer = 16
+
+// delta is a pair of read/written byte counts reported to an observer.
+type delta struct {
+	read, written uint64
+}
+
+// observer delivers deltas to a single Subscribe callback on its own
+// goroutine so a slow consumer can never block the data path.
+type observer struct {
+	ch   chan delta
+	done chan struct{}
+}
+
+func newObserver(fn func(readDelta, writtenDelta uint64)) *observer {
+	obs := &observer{
+		ch:   make(chan delta, observerBuffer),
+		done: make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case d := <-obs.ch:
+				select {
+				case <-obs.done:
+					return
+				default:
+				}
+				fn(d.read, d.written)
+			case <-obs.done:
+				return
+			}
+		}
+	}()
+	return obs
+}
+
+// send delivers d to the observer, dropping the oldest buffered delta
+// instead of blocking if the observer is falling behind.
+func (obs *observer) send(d delta) {
+	select {
+	case obs.ch <- d:
+		return
+	default:
+	}
+	select {
+	case <-obs.ch:
+	default:
+	}
+	select {
+	case obs.ch <- d:
+	default:
+	}
+}
+
+func (obs *observer) close() {
+	close(obs.done)
+}
+
+// Subscribe registers fn to be called with the read/written byte deltas of
+// every Read/Write. See Conn.Subscribe.
+func (conn *basicConn) Subscribe(fn func(readDelta, writtenDelta uint64)) func() {
+	obs := newObserver(fn)
+
+	conn.observersMu.Lock()
+	conn.observers = append(conn.observers, obs)
+	conn.observersMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			conn.observersMu.Lock()
+			for i, o := range conn.observers {
+				if o == obs {
+					conn.observers = append(conn.observers[:i], conn.observers[i+1:]...)
+					break
+				}
+			}
+			conn.observersMu.Unlock()
+
+			obs.close()
+		})
+	}
+}
+
+// notify fans the given deltas out to every subscribed observer.
+func (conn *basicConn) notify(readDelta, writtenDelta uint64) {
+	conn.observersMu.RLock()
+	defer conn.observersMu.RUnlock()
+	if len(conn.observers) == 0 {
+		return
+	}
+
+	d := delta{read: readDelta, written: writtenDelta}
+	for _, obs := range conn.observers {
+		obs.send(d)
+	}
+}
+
+// closeObservers shuts down every observer goroutine still registered on
+// conn, as a backstop for callers that Subscribe but never call the
+// returned unsubscribe func themselves.
+func (conn *basicConn) closeObservers() {
+	conn.observersMu.Lock()
+	observers := conn.observers
+	conn.observers = nil
+	conn.observersMu.Unlock()
+
+	for _, obs := range observers {
+		obs.close()
+	}
+}
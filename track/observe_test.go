@@ -0,0 +1,107 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"testing"
+	"time"
+)
+
+// TestObserverDropOldest exercises observer.send's slow-consumer behavior
+// directly: once the buffered channel fills up, send must drop the oldest
+// undelivered delta rather than block the caller.
+func TestObserverDropOldest(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan delta, observerBuffer+1)
+	obs := newObserver(func(r, w uint64) {
+		<-release
+		received <- delta{read: r, written: w}
+	})
+	defer obs.close()
+
+	// The first send is picked up immediately by the observer goroutine,
+	// which then blocks in fn waiting on release - simulating a slow
+	// consumer. Everything sent after this point piles up in obs.ch.
+	obs.send(delta{read: 0})
+
+	const total = observerBuffer + 5
+	for i := 1; i <= total; i++ {
+		done := make(chan struct{})
+		go func() {
+			obs.send(delta{read: uint64(i)})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("send(%d) blocked instead of dropping the oldest buffered delta", i)
+		}
+	}
+
+	close(release)
+
+	var got []uint64
+	for i := 0; i < 1+observerBuffer; i++ {
+		select {
+		case d := <-received:
+			got = append(got, d.read)
+		case <-time.After(time.Second):
+			t.Fatalf("only received %d deltas, want %d", len(got), 1+observerBuffer)
+		}
+	}
+
+	if got[0] != 0 {
+		t.Fatalf("first delivered delta = %d, want 0 (the one consumed before the buffer filled)", got[0])
+	}
+	last := got[len(got)-1]
+	if last != total {
+		t.Fatalf("last delivered delta = %d, want %d (the most recent send)", last, total)
+	}
+	for _, v := range got[1:] {
+		if v < uint64(total-observerBuffer+1) {
+			t.Fatalf("delta %d survived, want only the most recent %d sends after the oldest were dropped", v, observerBuffer)
+		}
+	}
+}
+
+// TestObserverCloseStopsDelivery verifies that once close is called, the
+// observer's goroutine exits and stops delivering to fn.
+func TestObserverCloseStopsDelivery(t *testing.T) {
+	var calls int
+	done := make(chan struct{})
+	obs := newObserver(func(r, w uint64) {
+		calls++
+		done <- struct{}{}
+	})
+
+	obs.send(delta{read: 1})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("observer never delivered the first delta")
+	}
+
+	obs.close()
+	obs.send(delta{read: 2})
+
+	select {
+	case <-done:
+		t.Fatal("observer delivered a delta sent after close")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import "time"
+
+// defaultIdleTimeout is the deadline pushed onto the wrapped net.Conn after
+// every Read/Write when no other policy has been configured.
+const defaultIdleTimeout = 60 * time.Second
+
+// Option configures the deadline policy and lifecycle hooks used by a Conn
+// returned from NewConn.
+type Option func(*connConfig)
+
+// connConfig holds the resolved configuration built up from a set of Options.
+type connConfig struct {
+	noDeadline   bool
+	deadlineFunc func() time.Time
+	onClose      func()
+}
+
+// newConnConfig builds the default configuration and applies opts on top of it.
+func newConnConfig(opts ...Option) *connConfig {
+	cfg := &connConfig{
+		deadlineFunc: func() time.Time {
+			return time.Now().Add(defaultIdleTimeout)
+		},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithIdleTimeout sets the duration added to time.Now() to compute the
+// read/write deadline pushed onto the connection after every Read/Write.
+// It overrides the default 60 second idle timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(cfg *connConfig) {
+		cfg.noDeadline = false
+		cfg.deadlineFunc = func() time.Time {
+			return time.Now().Add(d)
+		}
+	}
+}
+
+// WithNoDeadline disables automatic deadline management entirely, leaving
+// any deadlines on the wrapped net.Conn under the caller's control.
+func WithNoDeadline() Option {
+	return func(cfg *connConfig) {
+		cfg.noDeadline = true
+	}
+}
+
+// WithDeadlineFunc sets a custom function used to compute the deadline
+// pushed onto the connection after every Read/Write, for callers
+// that need a policy other than a fixed idle timeout.
+func WithDeadlineFunc(fn func() time.Time) Option {
+	return func(cfg *connConfig) {
+		cfg.noDeadline = false
+		cfg.deadlineFunc = fn
+	}
+}
+
+// WithOnClose registers a function to be called after the wrapped net.Conn
+// is closed.
+func WithOnClose(fn func()) Option {
+	return func(cfg *connConfig) {
+		cfg.onClose = fn
+	}
+}
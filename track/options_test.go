@@ -0,0 +1,119 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// deadlineSpyConn wraps a net.Conn and counts SetReadDeadline/
+// SetWriteDeadline calls, so tests can observe newConn's deadline policy
+// without depending on net.Conn exposing a deadline getter.
+type deadlineSpyConn struct {
+	net.Conn
+	readDeadlines, writeDeadlines int
+	lastReadDeadline              time.Time
+}
+
+func (c *deadlineSpyConn) SetReadDeadline(t time.Time) error {
+	c.readDeadlines++
+	c.lastReadDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *deadlineSpyConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadlines++
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	spy := &deadlineSpyConn{Conn: client}
+
+	tc := NewConn(spy, WithIdleTimeout(5*time.Second))
+	if spy.readDeadlines != 1 {
+		t.Fatalf("readDeadlines after construction = %d, want 1", spy.readDeadlines)
+	}
+	if d := time.Until(spy.lastReadDeadline); d < 4*time.Second || d > 6*time.Second {
+		t.Fatalf("deadline %v from now, want roughly 5s", d)
+	}
+
+	go server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := tc.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if spy.readDeadlines != 2 {
+		t.Fatalf("readDeadlines after Read = %d, want 2 (refreshed)", spy.readDeadlines)
+	}
+}
+
+func TestWithNoDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	spy := &deadlineSpyConn{Conn: client}
+
+	tc := NewConn(spy, WithNoDeadline())
+	if spy.readDeadlines != 0 || spy.writeDeadlines != 0 {
+		t.Fatalf("deadlines set at construction = (%d, %d), want (0, 0)", spy.readDeadlines, spy.writeDeadlines)
+	}
+
+	go server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := tc.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if spy.readDeadlines != 0 {
+		t.Fatalf("readDeadlines after Read = %d, want 0", spy.readDeadlines)
+	}
+}
+
+func TestWithDeadlineFunc(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	spy := &deadlineSpyConn{Conn: client}
+
+	fixed := time.Now().Add(time.Hour)
+	tc := NewConn(spy, WithDeadlineFunc(func() time.Time { return fixed }))
+	if !spy.lastReadDeadline.Equal(fixed) {
+		t.Fatalf("lastReadDeadline = %v, want %v", spy.lastReadDeadline, fixed)
+	}
+
+	go server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := tc.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !spy.lastReadDeadline.Equal(fixed) {
+		t.Fatalf("lastReadDeadline after Read = %v, want unchanged %v", spy.lastReadDeadline, fixed)
+	}
+}
+
+func TestWithOnClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	var called bool
+	tc := NewConn(client, WithOnClose(func() { called = true }))
+	if err := tc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if !called {
+		t.Fatal("WithOnClose hook was not called on Close")
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var pipeCounter uint64
+
+// Pipe returns a pair of connected, in-memory Conns with synthetic
+// addresses, for tests that want to exercise code written against Conn
+// without opening a real socket. Each side's Read blocks until the other
+// side Writes or Closes; Close propagates a sticky error (io.EOF to a
+// blocked or future Read, io.ErrClosedPipe to a future Write) to the peer.
+// Neither side has an auto-refreshing idle deadline; call
+// SetReadDeadline/SetWriteDeadline/SetDeadline explicitly to exercise that
+// behavior.
+func Pipe() (Conn, Conn) {
+	id := atomic.AddUint64(&pipeCounter, 1)
+	addrA := pipeAddr(fmt.Sprintf("pipe:%d:a", id))
+	addrB := pipeAddr(fmt.Sprintf("pipe:%d:b", id))
+
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+
+	a := &pipeHalf{p: p, isA: true, local: addrA, remote: addrB}
+	b := &pipeHalf{p: p, isA: false, local: addrB, remote: addrA}
+	return NewConn(a, WithNoDeadline()), NewConn(b, WithNoDeadline())
+}
+
+// pipeAddr is a synthetic net.Addr for a Pipe endpoint.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipe is the shared state of a connected pipeHalf pair: aToB holds bytes
+// written by the "a" half and not yet read by "b", and vice versa for bToA.
+type pipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	aToB, bToA       []byte
+	aClosed, bClosed bool
+}
+
+// pipeHalf is one endpoint of a Pipe, implementing net.Conn over the shared
+// in-memory pipe buffer.
+type pipeHalf struct {
+	p             *pipe
+	isA           bool
+	local, remote net.Addr
+
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+}
+
+// outBuf is the queue this half appends to on Write.
+func (c *pipeHalf) outBuf() *[]byte {
+	if c.isA {
+		return &c.p.aToB
+	}
+	return &c.p.bToA
+}
+
+// inBuf is the queue this half consumes from on Read.
+func (c *pipeHalf) inBuf() *[]byte {
+	if c.isA {
+		return &c.p.bToA
+	}
+	return &c.p.aToB
+}
+
+func (c *pipeHalf) localClosed() *bool {
+	if c.isA {
+		return &c.p.aClosed
+	}
+	return &c.p.bClosed
+}
+
+func (c *pipeHalf) peerClosed() *bool {
+	if c.isA {
+		return &c.p.bClosed
+	}
+	return &c.p.aClosed
+}
+
+func (c *pipeHalf) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	for {
+		in := c.inBuf()
+		if len(*in) > 0 {
+			n := copy(b, *in)
+			*in = (*in)[n:]
+			return n, nil
+		}
+		if *c.localClosed() {
+			return 0, io.ErrClosedPipe
+		}
+		if *c.peerClosed() {
+			return 0, io.EOF
+		}
+		if d := c.getReadDeadline(); !d.IsZero() {
+			if !time.Now().Before(d) {
+				return 0, os.ErrDeadlineExceeded
+			}
+		}
+		c.p.cond.Wait()
+	}
+}
+
+func (c *pipeHalf) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	if *c.localClosed() || *c.peerClosed() {
+		return 0, io.ErrClosedPipe
+	}
+	if d := c.getWriteDeadline(); !d.IsZero() && !time.Now().Before(d) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	out := c.outBuf()
+	*out = append(*out, b...)
+	c.p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (c *pipeHalf) Close() error {
+	c.p.mu.Lock()
+	*c.localClosed() = true
+	c.p.cond.Broadcast()
+	c.p.mu.Unlock()
+
+	c.deadlineMu.Lock()
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+	}
+	c.deadlineMu.Unlock()
+	return nil
+}
+
+func (c *pipeHalf) LocalAddr() net.Addr  { return c.local }
+func (c *pipeHalf) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeHalf) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline arranges for a blocked or future Read to unblock with
+// os.ErrDeadlineExceeded once t passes.
+func (c *pipeHalf) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	c.readDeadline = t
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+		c.readTimer = nil
+	}
+	if !t.IsZero() {
+		c.readTimer = time.AfterFunc(time.Until(t), func() {
+			c.p.mu.Lock()
+			c.p.cond.Broadcast()
+			c.p.mu.Unlock()
+		})
+	}
+	return nil
+}
+
+func (c *pipeHalf) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *pipeHalf) getReadDeadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.readDeadline
+}
+
+func (c *pipeHalf) getWriteDeadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.writeDeadline
+}
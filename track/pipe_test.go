@@ -0,0 +1,326 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPipeReadWrite(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if a.LocalAddr().String() == b.LocalAddr().String() {
+		t.Fatal("expected distinct synthetic addresses")
+	}
+	if a.LocalAddr().String() != b.RemoteAddr().String() {
+		t.Fatal("expected a's local addr to be b's remote addr")
+	}
+
+	go func() {
+		if _, err := b.Write([]byte("hello")); err != nil {
+			t.Errorf("write: %v", err)
+		}
+	}()
+
+	buf := make([]byte, 5)
+	n, err := a.Read(buf)
+	if err != nil || n != 5 || string(buf) != "hello" {
+		t.Fatalf("read = %d, %q, %v", n, buf, err)
+	}
+
+	read, written := a.Totals()
+	if read != 5 {
+		t.Fatalf("a.Totals read = %d, want 5", read)
+	}
+	_ = written
+}
+
+func TestPipeReadBlocksUntilData(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		a.Read(buf)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Read returned before any data was written")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := b.Write([]byte("x")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Read did not unblock after Write")
+	}
+}
+
+func TestPipeCloseSticksToPeer(t *testing.T) {
+	a, b := Pipe()
+	defer b.Close()
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := b.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected io.ErrClosedPipe from Write after peer Close, got %v", err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := b.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF from Read after peer Close, got %v", err)
+	}
+}
+
+func TestPipeReadDeadline(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	a.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 1)
+	start := time.Now()
+	_, err := a.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("deadline not respected promptly, took %v", elapsed)
+	}
+}
+
+func TestConnSubscribeAndUnsubscribe(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	type delta struct{ r, w uint64 }
+	deltas := make(chan delta, 10)
+	unsubscribe := a.Subscribe(func(r, w uint64) {
+		deltas <- delta{r, w}
+	})
+
+	go b.Write([]byte("abc"))
+	buf := make([]byte, 3)
+	if _, err := a.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	select {
+	case d := <-deltas:
+		if d.r != 3 {
+			t.Fatalf("got delta %+v, want r=3", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for observer delta")
+	}
+
+	unsubscribe()
+
+	go b.Write([]byte("xyz"))
+	buf = make([]byte, 3)
+	if _, err := a.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	select {
+	case d := <-deltas:
+		t.Fatalf("observer fired after unsubscribe: %+v", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRegistrySnapshotAndAggregate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	tl := NewListener(ln)
+	readDone := make(chan struct{})
+	closeNow := make(chan struct{})
+	acceptErr := make(chan error, 1)
+	go func() {
+		conn, err := tl.Accept()
+		if err != nil {
+			acceptErr <- err
+			return
+		}
+		buf := make([]byte, 3)
+		conn.Read(buf)
+		close(readDone)
+		<-closeNow
+		conn.Close()
+		acceptErr <- nil
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("abc")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case <-readDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("registry's tracked conn never observed the write")
+	}
+
+	totalRead, _, active := tl.Registry().Aggregate()
+	if totalRead != 3 || active != 1 {
+		t.Fatalf("Aggregate() = (%d, _, %d), want (3, _, 1)", totalRead, active)
+	}
+	snapshot := tl.Registry().Snapshot()
+	if len(snapshot) != 1 || snapshot[0].BytesRead != 3 {
+		t.Fatalf("Snapshot() = %+v, want one entry with BytesRead=3", snapshot)
+	}
+
+	close(closeNow)
+	if err := <-acceptErr; err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		if _, _, active := tl.Registry().Aggregate(); active == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("conn was never deregistered after Close")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestRegistryGlobalLimitRebalances(t *testing.T) {
+	reg := NewRegistry()
+	reg.SetGlobalLimit(1000, NoLimit)
+
+	a1, a2 := Pipe()
+	defer a1.Close()
+	defer a2.Close()
+	b1, b2 := Pipe()
+	defer b1.Close()
+	defer b2.Close()
+
+	rl1 := reg.wrapRateLimited(a1, 1000, NoLimit, 100).(RateLimiter)
+	if got := rl1.(*rateLimitedConn).readLimiter; got.rate != 1000 {
+		t.Fatalf("solo conn share = %v, want 1000", got.rate)
+	}
+
+	rl2 := reg.wrapRateLimited(b1, 1000, NoLimit, 100).(RateLimiter)
+	_ = rl2
+
+	if got := rl1.(*rateLimitedConn).readLimiter; got.rate != 500 {
+		t.Fatalf("rebalanced share = %v, want 500 with two members", got.rate)
+	}
+
+	_ = a2
+	_ = b2
+}
+
+func TestRegistryAggregateHistograms(t *testing.T) {
+	reg := NewRegistry()
+
+	a1, a2 := Pipe()
+	defer a1.Close()
+	defer a2.Close()
+	b1, b2 := Pipe()
+	defer b1.Close()
+	defer b2.Close()
+
+	ta := reg.wrap(a1)
+	tb := reg.wrap(b1)
+
+	go a2.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := ta.Read(buf); err != nil {
+		t.Fatalf("read a: %v", err)
+	}
+	go b2.Write([]byte("world"))
+	if _, err := tb.Read(buf); err != nil {
+		t.Fatalf("read b: %v", err)
+	}
+
+	readLatency, _, readSize, _ := reg.AggregateHistograms()
+	if readLatency.Count() != 2 {
+		t.Fatalf("aggregate readLatency.Count() = %d, want 2", readLatency.Count())
+	}
+	if readSize.Count() != 2 || readSize.Sum() != 10 {
+		t.Fatalf("aggregate readSize = (count %d, sum %d), want (2, 10)", readSize.Count(), readSize.Sum())
+	}
+}
+
+func TestHistogramPercentileAndMerge(t *testing.T) {
+	h := newShardedHistogram()
+	for i := int64(1); i <= 100; i++ {
+		h.record(i)
+	}
+	if h.Count() != 100 {
+		t.Fatalf("Count() = %d, want 100", h.Count())
+	}
+	if p50 := h.Percentile(0.5); p50 < 40 || p50 > 60 {
+		t.Fatalf("Percentile(0.5) = %d, want roughly 50", p50)
+	}
+
+	other := newShardedHistogram()
+	for i := int64(1); i <= 100; i++ {
+		other.record(i)
+	}
+	h.Merge(other)
+	if h.Count() != 200 {
+		t.Fatalf("Count() after Merge = %d, want 200", h.Count())
+	}
+}
+
+func TestTokenBucketClampNeverExceedsBurst(t *testing.T) {
+	b := newTokenBucket(10, 5)
+	if n := b.clamp(100); n != 5 {
+		t.Fatalf("clamp(100) = %d, want 5 (burst)", n)
+	}
+	if n := b.clamp(2); n != 2 {
+		t.Fatalf("clamp(2) = %d, want 2", n)
+	}
+}
+
+func TestTokenBucketNoLimitIsInstant(t *testing.T) {
+	b := newTokenBucket(NoLimit, 1)
+	start := time.Now()
+	if err := b.wait(1_000_000, time.Time{}); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatal("NoLimit bucket should never block")
+	}
+}
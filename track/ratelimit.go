@@ -0,0 +1,248 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NoLimit disables shaping for a direction passed to NewRateLimitedConn,
+// SetReadLimit, or SetWriteLimit.
+const NoLimit int64 = -1
+
+// RateLimiter is a Conn whose read/write throughput is shaped by a
+// token-bucket and can be reconfigured live.
+type RateLimiter interface {
+	Conn
+
+	// SetReadLimit changes the read-side rate in bytes per second, or
+	// NoLimit to disable shaping.
+	SetReadLimit(bps int64)
+
+	// SetWriteLimit changes the write-side rate in bytes per second, or
+	// NoLimit to disable shaping.
+	SetWriteLimit(bps int64)
+}
+
+// NewRateLimitedConn wraps conn (tracking it with NewConn first if it isn't
+// already a Conn) so that Read and Write are shaped by a token bucket: the
+// bucket holds up to burst bytes and refills at readBps/writeBps bytes per
+// second, blocking Read/Write when it is empty. Pass NoLimit for a
+// direction to disable shaping for it. A blocked Read/Write respects
+// whatever read/write deadline is set on the Conn (see SetReadDeadline/
+// SetWriteDeadline/SetDeadline), returning os.ErrDeadlineExceeded if it
+// elapses before enough tokens are available.
+func NewRateLimitedConn(conn net.Conn, readBps, writeBps int64, burst int64) RateLimiter {
+	tracked, ok := conn.(Conn)
+	if !ok {
+		// The rate limiter manages its own deadline bookkeeping below, so
+		// the wrapped Conn shouldn't also be auto-refreshing one underneath
+		// it.
+		tracked = NewConn(conn, WithNoDeadline())
+	}
+	return &rateLimitedConn{
+		Conn:         tracked,
+		readLimiter:  newTokenBucket(readBps, burst),
+		writeLimiter: newTokenBucket(writeBps, burst),
+	}
+}
+
+type rateLimitedConn struct {
+	Conn
+	readLimiter  *tokenBucket
+	writeLimiter *tokenBucket
+
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	n := c.readLimiter.clamp(len(b))
+	if err := c.readLimiter.wait(n, c.getReadDeadline()); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b[:n])
+}
+
+// Write shapes writes to the configured rate, looping internally so that,
+// like a plain net.Conn, it only returns a short write alongside an error.
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		chunk := c.writeLimiter.clamp(len(b) - written)
+		if err := c.writeLimiter.wait(chunk, c.getWriteDeadline()); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(b[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *rateLimitedConn) SetReadLimit(bps int64) {
+	c.readLimiter.setRate(bps)
+}
+
+func (c *rateLimitedConn) SetWriteLimit(bps int64) {
+	c.writeLimiter.setRate(bps)
+}
+
+// SetReadDeadline records the deadline for tokenBucket.wait to respect, in
+// addition to setting it on the wrapped Conn as usual.
+func (c *rateLimitedConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline records the deadline for tokenBucket.wait to respect, in
+// addition to setting it on the wrapped Conn as usual.
+func (c *rateLimitedConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.writeDeadline = t
+	c.deadlineMu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *rateLimitedConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *rateLimitedConn) getReadDeadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.readDeadline
+}
+
+func (c *rateLimitedConn) getWriteDeadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.writeDeadline
+}
+
+// tokenBucket implements token-bucket rate shaping: it holds up to capacity
+// tokens and refills at rate tokens per second.
+type tokenBucket struct {
+	// unlimited is read with atomic load/store so clamp and wait can skip
+	// the mutex entirely when shaping is disabled.
+	unlimited int32
+
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(bps, burst int64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	b :=&tokenBucket{capacity: float64(burst), tokens: float64(burst), last: time.Now()}
+	b.setRate(bps)
+	return b
+}
+
+func (b *tokenBucket) setRate(bps int64) {
+	if bps == NoLimit {
+		atomic.StoreInt32(&b.unlimited, 1)
+		return
+	}
+	b.mu.Lock()
+	b.rate = float64(bps)
+	b.mu.Unlock()
+	atomic.StoreInt32(&b.unlimited, 0)
+}
+
+// clamp caps n to the bucket's burst size, so wait is always asked for an
+// amount it can eventually satisfy.
+func (b *tokenBucket) clamp(n int) int {
+	if atomic.LoadInt32(&b.unlimited) == 1 || n <= 0 {
+		return n
+	}
+	b.mu.Lock()
+	burst := int(b.capacity)
+	b.mu.Unlock()
+	if burst < 1 {
+		burst = 1
+	}
+	if n > burst {
+		return burst
+	}
+	return n
+}
+
+// wait blocks until n tokens are available, returning immediately (nil
+// error) if shaping is disabled. If deadline is non-zero and elapses before
+// enough tokens accumulate, wait returns os.ErrDeadlineExceeded promptly
+// instead of continuing to block.
+func (b *tokenBucket) wait(n int, deadline time.Time) error {
+	if n <= 0 {
+		return nil
+	}
+	for {
+		if atomic.LoadInt32(&b.unlimited) == 1 {
+			return nil
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+
+		b.mu.Lock()
+		now := time.Now()
+		if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+			b.tokens += elapsed * b.rate
+			if b.tokens > b.capacity {
+				b.tokens = b.capacity
+			}
+			b.last = now
+		}
+		need := float64(n) - b.tokens
+		if need <= 0 {
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		rate := b.rate
+		b.mu.Unlock()
+
+		sleep := 100 * time.Millisecond
+		if rate > 0 {
+			if s := time.Duration(need / rate * float64(time.Second)); s < sleep {
+				sleep = s
+			}
+		}
+		if !deadline.IsZero() {
+			if remaining := time.Until(deadline); remaining < sleep {
+				sleep = remaining
+			}
+		}
+		if sleep > 0 {
+			time.Sleep(sleep)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRateLimitedConnReadDeadline mirrors TestPipeReadDeadline: a Read that
+// can't get enough tokens before its deadline elapses must unblock with
+// os.ErrDeadlineExceeded promptly, rather than keep waiting for tokens.
+func TestRateLimitedConnReadDeadline(t *testing.T) {
+	a, b := Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	rl := NewRateLimitedConn(a, 10, NoLimit, 10)
+	rl.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	go b.Write(make([]byte, 10))
+	buf := make([]byte, 10)
+	if _, err := rl.Read(buf); err != nil {
+		t.Fatalf("first read (burst not yet exhausted): %v", err)
+	}
+
+	start := time.Now()
+	_, err := rl.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded once the token bucket is exhausted, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("deadline not respected promptly, took %v", elapsed)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 Eric Daniels
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package track
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats is a point-in-time snapshot of a single tracked Conn held by a
+// Registry.
+type ConnStats struct {
+	RemoteAddr   string
+	OpenedAt     time.Time
+	BytesRead    uint64
+	BytesWritten uint64
+	LastActivity time.Time
+}
+
+// Registry is an aggregate view over a set of tracked Conns, populated by a
+// Listener or Dialer as connections are accepted or dialed.
+type Registry struct {
+	mu    sync.RWMutex
+	conns map[Conn]*registryEntry
+
+	rlMu           sync.Mutex
+	rateLimited    map[RateLimiter]struct{}
+	globalReadBps  int64
+	globalWriteBps int64
+}
+
+type registryEntry struct {
+	remoteAddr string
+	openedAt   time.Time
+
+	// lastActivityNano is updated from the Conn's Subscribe callback on
+	// every Read/Write, so it is accessed atomically rather than under mu.
+	lastActivityNano int64
+
+	// unsubscribe tears down the Subscribe call add registered, so remove
+	// doesn't leak the observer goroutine behind it.
+	unsubscribe func()
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		conns:          make(map[Conn]*registryEntry),
+		rateLimited:    make(map[RateLimiter]struct{}),
+		globalReadBps:  NoLimit,
+		globalWriteBps: NoLimit,
+	}
+}
+
+// wrap wraps c in a Conn using opts, registers it, and arranges for it to be
+// deregistered when it is closed.
+func (r *Registry) wrap(c net.Conn, opts ...Option) Conn {
+	var tracked Conn
+	opts = append(opts, WithOnClose(func() {
+		r.remove(tracked)
+	}))
+	tracked = NewConn(c, opts...)
+	r.add(tracked)
+	return tracked
+}
+
+// wrapRateLimited is like wrap, but additionally shapes the Conn with a
+// token bucket so it participates in the Registry's global rate limit.
+func (r *Registry) wrapRateLimited(c net.Conn, readBps, writeBps, burst int64) Conn {
+	var tracked Conn
+	base := NewConn(c, WithNoDeadline(), WithOnClose(func() {
+		r.remove(tracked)
+	}))
+	tracked = &rateLimitedConn{
+		Conn:         base,
+		readLimiter:  newTokenBucket(readBps, burst),
+		writeLimiter: newTokenBucket(writeBps, burst),
+	}
+	r.add(tracked)
+	return tracked
+}
+
+func (r *Registry) add(conn Conn) {
+	now := time.Now()
+	entry := &registryEntry{
+		remoteAddr:       conn.RemoteAddr().String(),
+		openedAt:         now,
+		lastActivityNano: now.UnixNano(),
+	}
+	entry.unsubscribe = conn.Subscribe(func(readDelta, writtenDelta uint64) {
+		atomic.StoreInt64(&entry.lastActivityNano, time.Now().UnixNano())
+	})
+
+	r.mu.Lock()
+	r.conns[conn] = entry
+	r.mu.Unlock()
+
+	if rl, ok := conn.(RateLimiter); ok {
+		r.rlMu.Lock()
+		r.rateLimited[rl] = struct{}{}
+		r.rebalanceLocked()
+		r.rlMu.Unlock()
+	}
+}
+
+func (r *Registry) remove(conn Conn) {
+	r.mu.Lock()
+	entry, ok := r.conns[conn]
+	delete(r.conns, conn)
+	r.mu.Unlock()
+	if ok {
+		entry.unsubscribe()
+	}
+
+	if rl, ok := conn.(RateLimiter); ok {
+		r.rlMu.Lock()
+		delete(r.rateLimited, rl)
+		r.rebalanceLocked()
+		r.rlMu.Unlock()
+	}
+}
+
+// SetGlobalLimit sets an aggregate rate, in bytes per second, shared
+// proportionally across every RateLimiter Conn currently tracked by the
+// Registry: each one's individual limit is rebalanced to bps/N, where N is
+// the number of tracked RateLimiter Conns, whenever the set of connections
+// or the global limit itself changes. Pass NoLimit to stop managing a
+// direction's per-conn limits.
+func (r *Registry) SetGlobalLimit(readBps, writeBps int64) {
+	r.rlMu.Lock()
+	defer r.rlMu.Unlock()
+	r.globalReadBps = readBps
+	r.globalWriteBps = writeBps
+	r.rebalanceLocked()
+}
+
+// rebalanceLocked recomputes each tracked RateLimiter's share of the global
+// limit. r.rlMu must be held.
+func (r *Registry) rebalanceLocked() {
+	n := int64(len(r.rateLimited))
+	if n == 0 {
+		return
+	}
+
+	readShare := NoLimit
+	if r.globalReadBps != NoLimit {
+		readShare = maxInt64(r.globalReadBps/n, 1)
+	}
+	writeShare := NoLimit
+	if r.globalWriteBps != NoLimit {
+		writeShare = maxInt64(r.globalWriteBps/n, 1)
+	}
+	if readShare == NoLimit && writeShare == NoLimit {
+		return
+	}
+
+	for rl := range r.rateLimited {
+		if readShare != NoLimit {
+			rl.SetReadLimit(readShare)
+		}
+		if writeShare != NoLimit {
+			rl.SetWriteLimit(writeShare)
+		}
+	}
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Snapshot returns the current stats of every tracked Conn.
+func (r *Registry) Snapshot() []ConnStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := make([]ConnStats, 0, len(r.conns))
+	for conn, entry := range r.conns {
+		read, written := conn.Totals()
+		stats = append(stats, ConnStats{
+			RemoteAddr:   entry.remoteAddr,
+			OpenedAt:     entry.openedAt,
+			BytesRead:    read,
+			BytesWritten: written,
+			LastActivity: time.Unix(0, atomic.LoadInt64(&entry.lastActivityNano)),
+		})
+	}
+	return stats
+}
+
+// Aggregate returns the combined read/written byte totals and the number of
+// currently tracked Conns.
+func (r *Registry) Aggregate() (totalRead, totalWritten uint64, active int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for conn := range r.conns {
+		read, written := conn.Totals()
+		totalRead += read
+		totalWritten += written
+	}
+	return totalRead, totalWritten, len(r.conns)
+}
+
+// AggregateHistograms merges every currently tracked Conn's latency and I/O
+// size histograms together, letting a Registry report distributions across
+// its whole connection set rather than per-Conn.
+func (r *Registry) AggregateHistograms() (readLatency, writeLatency, readSize, writeSize Histogram) {
+	readLatency, writeLatency = NewHistogram(), NewHistogram()
+	readSize, writeSize = NewHistogram(), NewHistogram()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for conn := range r.conns {
+		readLatency.Merge(conn.ReadLatency())
+		writeLatency.Merge(conn.WriteLatency())
+		connReadSize, connWriteSize := conn.IOSizes()
+		readSize.Merge(connReadSize)
+		writeSize.Merge(connWriteSize)
+	}
+	return readLatency, writeLatency, readSize, writeSize
+}
+
+// Range calls fn for every currently tracked Conn, stopping early if fn
+// returns false. fn is called outside of the Registry's lock, so it may
+// safely register new connections.
+func (r *Registry) Range(fn func(Conn) bool) {
+	r.mu.RLock()
+	conns := make([]Conn, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.RUnlock()
+
+	for _, conn := range conns {
+		if !fn(conn) {
+			return
+		}
+	}
+}